@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// completionActions are the actions offered by the shell completion
+var completionActions = []string{
+	actionAudit, actionCA, actionCheckExpiry, actionCompletion, actionCRL,
+	actionGenKey, actionHealth, actionList, actionListRevoked,
+	actionMakeClientConfig, actionMakeServerConfig, actionMetrics,
+	actionPKCS12, actionRenew, actionRevoke, actionRevokeExpired,
+	actionRevokeSerial, actionShow, actionSignCSR, actionTidy,
+	actionVerify,
+}
+
+const bashCompletion = `_vault_openvpn() {
+	local cur="${COMP_WORDS[COMP_CWORD]}"
+	local value_flags=" %[2]s "
+	local i word action
+
+	for ((i = 1; i < COMP_CWORD; i++)); do
+		word="${COMP_WORDS[i]}"
+		case "${word}" in
+		-*)
+			[[ "${value_flags}" == *" ${word} "* ]] && ((i++))
+			;;
+		*)
+			action="${word}"
+			break
+			;;
+		esac
+	done
+
+	if [[ "${cur}" == -* ]]; then
+		COMPREPLY=($(compgen -W "%[1]s" -- "${cur}"))
+	elif [[ -z "${action}" ]]; then
+		COMPREPLY=($(compgen -W "%[3]s" -- "${cur}"))
+	else
+		COMPREPLY=($(compgen -f -- "${cur}"))
+	fi
+}
+complete -F _vault_openvpn vault-openvpn
+`
+
+// writeCompletion outputs the completion script for the shell, zsh uses
+// the bash script through its bash compatibility
+func writeCompletion(w io.Writer, shell string) error {
+	flags, valueFlags := completionFlags()
+	script := fmt.Sprintf(bashCompletion, strings.Join(flags, " "), strings.Join(valueFlags, " "), strings.Join(completionActions, " "))
+
+	switch shell {
+	case "bash":
+	case "zsh":
+		script = "autoload -U +X bashcompinit && bashcompinit\n" + script
+	default:
+		return fmt.Errorf("Unsupported shell %q, must be one of bash, zsh", shell)
+	}
+
+	_, err := io.WriteString(w, script)
+	return err
+}
+
+// completionFlags reads the flags from the cfg struct tags and returns
+// all flags and the ones expecting a value
+func completionFlags() ([]string, []string) {
+	flags, valueFlags := []string{}, []string{}
+
+	t := reflect.TypeOf(cfg)
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("flag"), ",")[0]
+		if name == "" {
+			continue
+		}
+
+		flags = append(flags, "--"+name)
+		if t.Field(i).Type.Kind() != reflect.Bool {
+			valueFlags = append(valueFlags, "--"+name)
+		}
+	}
+
+	sort.Strings(flags)
+	sort.Strings(valueFlags)
+	return flags, valueFlags
+}
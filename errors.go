@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/Luzifer/rconfig"
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	exitCodeGeneric     = 1
+	exitCodeAuth        = 2
+	exitCodeNotFound    = 3
+	exitCodeTemplate    = 4
+	exitCodeUnreachable = 5
+)
+
+// exitError attaches the exit code to an error main should terminate
+// the program with when encountering it
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e exitError) Error() string { return e.err.Error() }
+func (e exitError) Unwrap() error { return e.err }
+
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return exitError{code: code, err: err}
+}
+
+// classifyVaultError assigns an exit code to errors returned by the
+// Vault API. As the API only returns formatted errors the permission
+// check has to be done on the error message.
+func classifyVaultError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var netErr net.Error
+	switch {
+	case errors.As(err, &netErr):
+		return withExitCode(exitCodeUnreachable, err)
+	case strings.Contains(err.Error(), "Code: 401"), strings.Contains(err.Error(), "Code: 403"):
+		return withExitCode(exitCodeAuth, err)
+	}
+
+	return err
+}
+
+func exitCodeForError(err error) int {
+	var e exitError
+	if errors.As(err, &e) {
+		return e.code
+	}
+	return exitCodeGeneric
+}
+
+// exitWithError logs the error and terminates the program using the
+// exit code matching the category of the error
+func exitWithError(msg string, err error) {
+	if cfg.Format == formatJSON {
+		writeJSONError(fmt.Sprintf("%s: %s", msg, err))
+	}
+	log.Errorf("%s: %s", msg, err)
+	os.Exit(exitCodeForError(err))
+}
+
+// errorFQDN is the FQDN the action was called for, reported in the JSON
+// error output
+var errorFQDN string
+
+type errorOutput struct {
+	Error  string `json:"error"`
+	Action string `json:"action,omitempty"`
+	FQDN   string `json:"fqdn,omitempty"`
+}
+
+// writeJSONError writes the error as JSON object to stdout for automation
+// using --format=json, the log line is still written to stderr
+func writeJSONError(msg string) {
+	out := errorOutput{Error: msg, FQDN: errorFQDN}
+	if len(rconfig.Args()) > 1 {
+		out.Action = rconfig.Args()[1]
+	}
+	json.NewEncoder(os.Stdout).Encode(out)
+}
+
+// jsonErrorHook writes the messages of log.Fatalf as JSON error output
+type jsonErrorHook struct{}
+
+func (jsonErrorHook) Levels() []log.Level { return []log.Level{log.FatalLevel, log.PanicLevel} }
+
+func (jsonErrorHook) Fire(entry *log.Entry) error {
+	writeJSONError(entry.Message)
+	return nil
+}
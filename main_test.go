@@ -0,0 +1,394 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/vault/api"
+	"github.com/hashicorp/vault/helper/certutil"
+)
+
+// fakeVault implements vaultPKI returning canned secrets by path
+type fakeVault struct {
+	secrets map[string]*api.Secret
+	writes  []string
+}
+
+func newFakeVault() *fakeVault {
+	return &fakeVault{secrets: map[string]*api.Secret{}}
+}
+
+func (f *fakeVault) Read(_ context.Context, path string) (*api.Secret, error) {
+	return f.secrets[path], nil
+}
+
+func (f *fakeVault) List(_ context.Context, path string) (*api.Secret, error) {
+	return f.secrets[path], nil
+}
+
+func (f *fakeVault) Write(_ context.Context, path string, _ map[string]interface{}) (*api.Secret, error) {
+	f.writes = append(f.writes, path)
+	return nil, nil
+}
+
+func (f *fakeVault) ReadRaw(_ context.Context, path string) ([]byte, error) {
+	return nil, fmt.Errorf("Unexpected raw read of %q", path)
+}
+
+// addCertificate stores a self-signed certificate in the pki mount of the
+// fake and adds it to the list of certificates. The revocation_time is
+// left out of the data if revocationTime is nil.
+func (f *fakeVault) addCertificate(t *testing.T, cn string, serial int64, notBefore, notAfter time.Time, revocationTime interface{}) string {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate key: %s", err)
+	}
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    notBefore,
+		NotAfter:     notAfter,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s", err)
+	}
+
+	hexSerial := certutil.GetHexFormatted(big.NewInt(serial).Bytes(), ":")
+	data := map[string]interface{}{
+		"certificate": string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})),
+	}
+	if revocationTime != nil {
+		data["revocation_time"] = revocationTime
+	}
+	f.secrets["pki/cert/"+hexSerial] = &api.Secret{Data: data}
+
+	list, ok := f.secrets["pki/certs"]
+	if !ok {
+		list = &api.Secret{Data: map[string]interface{}{"keys": []interface{}{}}}
+		f.secrets["pki/certs"] = list
+	}
+	list.Data["keys"] = append(list.Data["keys"].([]interface{}), hexSerial)
+
+	return hexSerial
+}
+
+// unixTime returns the time as Vault encodes the revocation_time
+func unixTime(t time.Time) json.Number {
+	return json.Number(strconv.FormatInt(t.Unix(), 10))
+}
+
+// resetConfig restores cfg after the test and sets the values the tests
+// would get from the defaults
+func resetConfig(t *testing.T) {
+	saved := cfg
+	t.Cleanup(func() { cfg = saved })
+
+	cfg.PKIMountPoint = "/pki"
+	cfg.Concurrency = 2
+	cfg.Format = formatJSON
+	cfg.Sort = sortFQDN
+	cfg.SortDesc = false
+	cfg.LatestOnly = false
+	cfg.IssuedWithin = 0
+	cfg.IncludeExpired = false
+	cfg.IncludeRevoked = false
+	cfg.Fields = "mount,fqdn,notbefore,notafter,serial,status"
+	cfg.SerialFormat = serialFormatColon
+	cfg.Quiet = true
+}
+
+// captureStdout returns what fn wrote to stdout
+func captureStdout(t *testing.T, fn func() error) string {
+	t.Helper()
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("Unable to create pipe: %s", err)
+	}
+
+	out := make(chan []byte)
+	go func() {
+		raw, _ := ioutil.ReadAll(r)
+		out <- raw
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	fnErr := fn()
+	os.Stdout = stdout
+	w.Close()
+
+	raw := <-out
+	if fnErr != nil {
+		t.Fatalf("Unexpected error: %s", fnErr)
+	}
+	return string(raw)
+}
+
+func TestFetchCertificatesFromVault(t *testing.T) {
+	resetConfig(t)
+
+	now := time.Now()
+	vault := newFakeVault()
+	vault.addCertificate(t, "valid.example.com", 1, now.Add(-time.Hour), now.Add(time.Hour), unixTime(time.Unix(0, 0)))
+	vault.addCertificate(t, "revoked.example.com", 2, now.Add(-time.Hour), now.Add(time.Hour), unixTime(now.Add(-time.Minute)))
+	vault.addCertificate(t, "expired.example.com", 3, now.Add(-2*time.Hour), now.Add(-time.Hour), nil)
+
+	for _, tc := range []struct {
+		includeRevoked, includeExpired bool
+		expected                       []string
+	}{
+		{false, false, []string{"valid.example.com"}},
+		{true, false, []string{"valid.example.com", "revoked.example.com"}},
+		{false, true, []string{"valid.example.com", "expired.example.com"}},
+		{true, true, []string{"valid.example.com", "revoked.example.com", "expired.example.com"}},
+	} {
+		states, err := fetchCertificatesFromVault(context.Background(), vault, cfg.PKIMountPoint, tc.includeRevoked, tc.includeExpired)
+		if err != nil {
+			t.Fatalf("Unexpected error: %s", err)
+		}
+
+		cns := []string{}
+		for _, state := range states {
+			cns = append(cns, state.Certificate.Subject.CommonName)
+		}
+		if !reflect.DeepEqual(cns, tc.expected) {
+			t.Errorf("includeRevoked=%t includeExpired=%t: expected %v, got %v", tc.includeRevoked, tc.includeExpired, tc.expected, cns)
+		}
+	}
+}
+
+func TestFetchCertificatesFromVaultWithoutCertificates(t *testing.T) {
+	resetConfig(t)
+
+	states, err := fetchCertificatesFromVault(context.Background(), newFakeVault(), cfg.PKIMountPoint, true, true)
+	if err != nil {
+		t.Fatalf("Unexpected error: %s", err)
+	}
+	if len(states) != 0 {
+		t.Errorf("Expected no certificates, got %d", len(states))
+	}
+}
+
+func TestListCertificates(t *testing.T) {
+	now := time.Now()
+	vault := newFakeVault()
+	a1 := vault.addCertificate(t, "a.example.com", 0x03, now.Add(-2*time.Hour), now.Add(30*24*time.Hour), nil)
+	b := vault.addCertificate(t, "b.example.com", 0x01, now.Add(-time.Hour), now.Add(10*24*time.Hour), nil)
+	a2 := vault.addCertificate(t, "a.example.com", 0x0100, now.Add(-time.Hour), now.Add(20*24*time.Hour), nil)
+	vault.addCertificate(t, "c.example.com", 0x02, now.Add(-time.Hour), now.Add(time.Hour), unixTime(now.Add(-time.Minute)))
+
+	for _, tc := range []struct {
+		name     string
+		setup    func()
+		filter   string
+		expected []string
+	}{
+		{"fqdn", func() {}, "", []string{a1, a2, b}},
+		{"fqdn-desc", func() { cfg.SortDesc = true }, "", []string{b, a2, a1}},
+		{"expiry", func() { cfg.Sort = sortExpiry }, "", []string{b, a2, a1}},
+		{"serial", func() { cfg.Sort = sortSerial }, "", []string{b, a1, a2}},
+		{"latest-only", func() { cfg.LatestOnly = true }, "", []string{a2, b}},
+		{"filter", func() {}, "B.example", []string{b}},
+		{"issued-within", func() { cfg.IssuedWithin = 90 * time.Minute }, "", []string{a2, b}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resetConfig(t)
+			tc.setup()
+
+			out := captureStdout(t, func() error {
+				return listCertificates(context.Background(), vault, tc.filter)
+			})
+
+			var result struct {
+				Count int                        `json:"count"`
+				Items []listCertificatesTableRow `json:"items"`
+			}
+			if err := json.Unmarshal([]byte(out), &result); err != nil {
+				t.Fatalf("Unable to parse output %q: %s", out, err)
+			}
+
+			serials := []string{}
+			for _, item := range result.Items {
+				serials = append(serials, item.Serial)
+			}
+			if !reflect.DeepEqual(serials, tc.expected) || result.Count != len(tc.expected) {
+				t.Errorf("Expected serials %v, got %v (count %d)", tc.expected, serials, result.Count)
+			}
+		})
+	}
+}
+
+func TestNormalizeSerial(t *testing.T) {
+	resetConfig(t)
+
+	for _, tc := range []struct {
+		in, format, expected string
+		fail                 bool
+	}{
+		{in: "01:ab:cd", expected: "01:ab:cd"},
+		{in: "01 ab cd", expected: "01:ab:cd"},
+		{in: "17-2a-3b", expected: "17:2a:3b"},
+		{in: "0a-ff", expected: "0a:ff"},
+		{in: "12-34", format: serialFormatDecimal, expected: "12:34"},
+		{in: "01abcd", expected: "01:ab:cd"},
+		{in: "01:AB:CD", expected: "01:ab:cd"},
+		{in: "01ABCD", expected: "01:ab:cd"},
+		{in: "0x1abcd", expected: "01:ab:cd"},
+		{in: " 1:ab:cd\n", expected: "01:ab:cd"},
+		{in: "256", expected: "02:56"},
+		{in: "256", format: serialFormatDecimal, expected: "01:00"},
+		{in: "01:00", format: serialFormatDecimal, expected: "01:00"},
+		{in: "xyz", fail: true},
+		{in: "", fail: true},
+		{in: "00:00", fail: true},
+	} {
+		cfg.SerialFormat = tc.format
+
+		serial, err := normalizeSerial(tc.in)
+		switch {
+		case tc.fail && err == nil:
+			t.Errorf("Expected %q (format %q) to fail, got %q", tc.in, tc.format, serial)
+		case !tc.fail && err != nil:
+			t.Errorf("Unexpected error for %q (format %q): %s", tc.in, tc.format, err)
+		case serial != tc.expected:
+			t.Errorf("Expected %q (format %q) to become %q, got %q", tc.in, tc.format, tc.expected, serial)
+		}
+	}
+}
+
+func TestParseExtraParams(t *testing.T) {
+	for _, tc := range []struct {
+		in       string
+		expected interface{}
+	}{
+		{"true", true},
+		{"false", false},
+		{"True", "True"},
+		{"60", int64(60)},
+		{"-1", int64(-1)},
+		{"0.5", 0.5},
+		{"01234", "01234"},
+		{"+1", "+1"},
+		{"1e3", "1e3"},
+		{"1.50", "1.50"},
+		{"inf", "inf"},
+		{"+Inf", "+Inf"},
+		{"NaN", "NaN"},
+		{"99999999999999999999", "99999999999999999999"},
+		{"a=b", "a=b"},
+		{"", ""},
+	} {
+		params, err := parseExtraParams([]string{"key=" + tc.in})
+		if err != nil {
+			t.Fatalf("Unexpected error for %q: %s", tc.in, err)
+		}
+		if params["key"] != tc.expected {
+			t.Errorf("Expected %q to become %#v, got %#v", tc.in, tc.expected, params["key"])
+		}
+	}
+
+	params, err := parseExtraParams([]string{"", " "})
+	if err != nil || len(params) != 0 {
+		t.Errorf("Expected empty entries to be skipped, got %v (%v)", params, err)
+	}
+
+	for _, param := range []string{"key", "=value", " =value"} {
+		if _, err := parseExtraParams([]string{param}); err == nil {
+			t.Errorf("Expected %q to fail", param)
+		}
+	}
+}
+
+func TestFetchCertificateBySerialRevocationTime(t *testing.T) {
+	resetConfig(t)
+
+	now := time.Now()
+	for _, tc := range []struct {
+		name           string
+		revocationTime interface{}
+		revoked        bool
+	}{
+		{"absent", nil, false},
+		{"zero", unixTime(time.Unix(0, 0)), false},
+		{"past", unixTime(now.Add(-time.Hour)), true},
+		// Clock skew between Vault and the client must not hide a revoke
+		{"future", unixTime(now.Add(time.Hour)), true},
+	} {
+		vault := newFakeVault()
+		serial := vault.addCertificate(t, "a.example.com", 0x1234, now.Add(-time.Hour), now.Add(time.Hour), tc.revocationTime)
+
+		state, err := fetchCertificateBySerial(context.Background(), vault, cfg.PKIMountPoint, serial)
+		if err != nil {
+			t.Fatalf("%s: Unexpected error: %s", tc.name, err)
+		}
+		if state.Revoked != tc.revoked {
+			t.Errorf("%s: Expected revoked to be %t, got %t", tc.name, tc.revoked, state.Revoked)
+		}
+	}
+}
+
+func TestFetchCertificateBySerialNotFound(t *testing.T) {
+	resetConfig(t)
+
+	_, err := fetchCertificateBySerial(context.Background(), newFakeVault(), cfg.PKIMountPoint, "12:34")
+	if exitCodeForError(err) != exitCodeNotFound {
+		t.Errorf("Expected not found error, got %v", err)
+	}
+}
+
+func TestFetchCACert(t *testing.T) {
+	resetConfig(t)
+	cfg.IssuerRef = ""
+
+	caPEM := "-----BEGIN CERTIFICATE-----\nca\n-----END CERTIFICATE-----\n"
+	for _, tc := range []struct {
+		name         string
+		includeChain bool
+		secrets      map[string]*api.Secret
+		expected     string
+	}{
+		{"ca", false, map[string]*api.Secret{
+			"pki/cert/ca": {Data: map[string]interface{}{"certificate": caPEM}},
+		}, caPEM},
+		{"empty-chain", true, map[string]*api.Secret{
+			"pki/cert/ca_chain": {Data: map[string]interface{}{"certificate": ""}},
+			"pki/cert/ca":       {Data: map[string]interface{}{"certificate": caPEM}},
+		}, caPEM},
+		{"missing-ca", false, map[string]*api.Secret{}, ""},
+		{"missing-ca-empty-chain", true, map[string]*api.Secret{
+			"pki/cert/ca": {Data: map[string]interface{}{"certificate": ""}},
+		}, ""},
+	} {
+		cfg.IncludeChain = tc.includeChain
+		vault := newFakeVault()
+		vault.secrets = tc.secrets
+
+		ca, err := fetchCACert(context.Background(), vault)
+		switch {
+		case tc.expected == "" && exitCodeForError(err) != exitCodeNotFound:
+			t.Errorf("%s: Expected not found error, got %q (%v)", tc.name, ca, err)
+		case tc.expected != "" && err != nil:
+			t.Errorf("%s: Unexpected error: %s", tc.name, err)
+		case ca != tc.expected:
+			t.Errorf("%s: Expected CA %q, got %q", tc.name, tc.expected, ca)
+		}
+	}
+}
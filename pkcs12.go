@@ -0,0 +1,35 @@
+package main
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+
+	pkcs12 "software.sslmate.com/src/go-pkcs12"
+)
+
+// encodePKCS12 creates a password protected PKCS#12 bundle containing the
+// private key, its certificate and the CA certificates. The legacy 3DES
+// and SHA-1 parameters are used as the modern ones can't be imported by
+// older Windows and OpenSSL versions still used by many clients.
+func encodePKCS12(privateKey interface{}, cert *x509.Certificate, caCerts []*x509.Certificate, password string) ([]byte, error) {
+	return pkcs12.LegacyDES.Encode(privateKey, cert, caCerts, password)
+}
+
+// parsePrivateKeyPEM reads the private key in any of the formats Vault
+// is able to return
+func parsePrivateKeyPEM(in string) (interface{}, error) {
+	block, _ := pem.Decode([]byte(in))
+	if block == nil {
+		return nil, errors.New("No PEM data found")
+	}
+
+	switch block.Type {
+	case "RSA PRIVATE KEY":
+		return x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		return x509.ParseECPrivateKey(block.Bytes)
+	default:
+		return x509.ParsePKCS8PrivateKey(block.Bytes)
+	}
+}
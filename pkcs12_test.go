@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/pkcs12"
+)
+
+// createSignedCertificate creates a certificate for the key signed by the
+// parent, a nil parent creates a self-signed CA certificate
+func createSignedCertificate(t *testing.T, cn string, key crypto.Signer, parent *x509.Certificate, parentKey crypto.Signer) *x509.Certificate {
+	t.Helper()
+
+	tpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: cn},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	if parent == nil {
+		tpl.IsCA, tpl.BasicConstraintsValid = true, true
+		tpl.KeyUsage = x509.KeyUsageCertSign
+		parent, parentKey = tpl, key
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, tpl, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatalf("Unable to create certificate: %s", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Unable to parse certificate: %s", err)
+	}
+	return cert
+}
+
+func TestEncodePKCS12(t *testing.T) {
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate CA key: %s", err)
+	}
+	ca := createSignedCertificate(t, "Test CA", caKey, nil, nil)
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("Unable to generate RSA key: %s", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Unable to generate EC key: %s", err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		key      crypto.Signer
+		password string
+	}{
+		{"rsa", rsaKey, "secret"},
+		{"rsa-empty-password", rsaKey, ""},
+		{"ec", ecKey, "secret"},
+		{"ec-non-ascii-password", ecKey, "Grüße-€-пароль"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cert := createSignedCertificate(t, "a.example.com", tc.key, ca, caKey)
+
+			bundle, err := encodePKCS12(tc.key, cert, []*x509.Certificate{ca}, tc.password)
+			if err != nil {
+				t.Fatalf("Unable to encode bundle: %s", err)
+			}
+
+			if _, err := pkcs12.ToPEM(bundle, tc.password+"x"); err == nil {
+				t.Error("Expected decoding with a wrong password to fail")
+			}
+
+			blocks, err := pkcs12.ToPEM(bundle, tc.password)
+			if err != nil {
+				t.Fatalf("Unable to decode bundle: %s", err)
+			}
+
+			certs := [][]byte{}
+			var key interface{}
+			for _, block := range blocks {
+				switch block.Type {
+				case "CERTIFICATE":
+					certs = append(certs, block.Bytes)
+				case "PRIVATE KEY":
+					// ToPEM converts the key to PKCS#1 / SEC 1
+					if key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+						key, err = x509.ParseECPrivateKey(block.Bytes)
+					}
+					if err != nil {
+						t.Fatalf("Unable to parse decoded key: %s", err)
+					}
+				default:
+					t.Errorf("Unexpected block of type %q", block.Type)
+				}
+			}
+
+			if len(certs) != 2 || !bytes.Equal(certs[0], cert.Raw) || !bytes.Equal(certs[1], ca.Raw) {
+				t.Errorf("Expected certificate and CA in the bundle, got %d certificates", len(certs))
+			}
+
+			signer, ok := key.(crypto.Signer)
+			if !ok {
+				t.Fatalf("Expected a private key in the bundle, got %T", key)
+			}
+			if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(tc.key.Public()) {
+				t.Error("Decoded key does not match the encoded one")
+			}
+		})
+	}
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// OpenVPN static keys used for tls-auth / tls-crypt consist of 256 random
+// bytes written as hex with 16 bytes per line, the same format as written
+// by `openvpn --genkey`.
+
+const staticKeySize = 256
+
+func generateStaticKey() (string, error) {
+	key := make([]byte, staticKeySize)
+	if _, err := rand.Read(key); err != nil {
+		return "", err
+	}
+
+	lines := []string{
+		"#",
+		fmt.Sprintf("# %d bit OpenVPN static key", staticKeySize*8),
+		"#",
+		"-----BEGIN OpenVPN Static key V1-----",
+	}
+	for i := 0; i < len(key); i += 16 {
+		lines = append(lines, hex.EncodeToString(key[i:i+16]))
+	}
+	lines = append(lines, "-----END OpenVPN Static key V1-----")
+
+	return strings.Join(lines, "\n") + "\n", nil
+}
+
+// loadStaticKey reads the static key from the file and generates it if
+// the file does not exist yet, so all configs share the same key
+func loadStaticKey(filename string) (string, error) {
+	raw, err := ioutil.ReadFile(filename)
+	switch {
+	case err == nil:
+		if !strings.Contains(string(raw), "-----BEGIN OpenVPN Static key V1-----") {
+			return "", fmt.Errorf("File %q does not contain an OpenVPN static key", filename)
+		}
+		return string(raw), nil
+
+	case os.IsNotExist(err):
+		key, err := generateStaticKey()
+		if err != nil {
+			return "", err
+		}
+		if err := ioutil.WriteFile(filename, []byte(key), 0600); err != nil {
+			return "", err
+		}
+		return key, nil
+
+	default:
+		return "", err
+	}
+}
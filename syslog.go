@@ -0,0 +1,58 @@
+//go:build !windows && !nacl && !plan9
+// +build !windows,!nacl,!plan9
+
+package main
+
+import (
+	"log/syslog"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The vendored logrus/hooks/syslog imports logrus by its lowercase path
+// and can't be used with the logger of this tool, so this is the same
+// hook without depending on it.
+
+type syslogHook struct {
+	writer    *syslog.Writer
+	formatter log.Formatter
+}
+
+// addSyslogHook sends all log entries to the local syslog in addition to
+// the regular log output
+func addSyslogHook() error {
+	w, err := syslog.New(syslog.LOG_INFO|syslog.LOG_USER, "vault-openvpn")
+	if err != nil {
+		return err
+	}
+
+	log.AddHook(syslogHook{
+		writer: w,
+		// Syslog adds its own timestamp and doesn't understand colors
+		formatter: &log.TextFormatter{DisableColors: true, DisableTimestamp: true},
+	})
+	return nil
+}
+
+func (syslogHook) Levels() []log.Level { return log.AllLevels }
+
+func (h syslogHook) Fire(entry *log.Entry) error {
+	raw, err := h.formatter.Format(entry)
+	if err != nil {
+		return err
+	}
+	line := string(raw)
+
+	switch entry.Level {
+	case log.PanicLevel, log.FatalLevel:
+		return h.writer.Crit(line)
+	case log.ErrorLevel:
+		return h.writer.Err(line)
+	case log.WarnLevel:
+		return h.writer.Warning(line)
+	case log.InfoLevel:
+		return h.writer.Info(line)
+	default:
+		return h.writer.Debug(line)
+	}
+}
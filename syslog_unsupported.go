@@ -0,0 +1,10 @@
+//go:build windows || nacl || plan9
+// +build windows nacl plan9
+
+package main
+
+import "errors"
+
+func addSyslogHook() error {
+	return errors.New("Syslog is not supported on this platform")
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/hashicorp/vault/api"
+)
+
+// The vendored Vault API supports neither contexts nor namespaces.
+// Therefore the requests are built here instead of using client.Logical()
+// to be able to add the namespace header, but behave like their
+// counterparts in the Vault API. They are executed in the background and
+// abandoned as soon as the context is done. Additionally the HTTP client
+// timeout is set to the same value in main to not keep abandoned requests
+// running forever. The client is passed down from main instead of being
+// shared through a package variable.
+
+func vaultRead(ctx context.Context, client *api.Client, path string) (*api.Secret, error) {
+	return vaultRequest(ctx, func() (*api.Secret, error) {
+		return doVaultRequest(client, newVaultRequest(client, "GET", path), true)
+	})
+}
+
+func vaultList(ctx context.Context, client *api.Client, path string) (*api.Secret, error) {
+	return vaultRequest(ctx, func() (*api.Secret, error) {
+		r := newVaultRequest(client, "LIST", path)
+		r.Method = "GET"
+		r.Params.Set("list", "true")
+		return doVaultRequest(client, r, true)
+	})
+}
+
+func vaultWrite(ctx context.Context, client *api.Client, path string, data map[string]interface{}) (*api.Secret, error) {
+	return vaultRequest(ctx, func() (*api.Secret, error) {
+		r := newVaultRequest(client, "PUT", path)
+		if err := r.SetJSONBody(data); err != nil {
+			return nil, err
+		}
+		return doVaultRequest(client, r, false)
+	})
+}
+
+// vaultReadRaw reads the response body of a path not returning a secret
+// like the CRL endpoints
+func vaultReadRaw(ctx context.Context, client *api.Client, path string) ([]byte, error) {
+	return vaultRequest(ctx, func() ([]byte, error) {
+		resp, err := client.RawRequest(newVaultRequest(client, "GET", path))
+		if resp != nil {
+			defer resp.Body.Close()
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		return ioutil.ReadAll(resp.Body)
+	})
+}
+
+func newVaultRequest(client *api.Client, method, path string) *api.Request {
+	r := client.NewRequest(method, "/v1/"+path)
+	if cfg.VaultNamespace != "" {
+		if r.Headers == nil {
+			r.Headers = http.Header{}
+		}
+		r.Headers.Set("X-Vault-Namespace", cfg.VaultNamespace)
+	}
+	return r
+}
+
+func doVaultRequest(client *api.Client, r *api.Request, nilOnNotFound bool) (*api.Secret, error) {
+	resp, err := client.RawRequest(r)
+	if resp != nil {
+		defer resp.Body.Close()
+	}
+	if nilOnNotFound && resp != nil && resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		// Writes without response (204) don't carry a secret
+		return nil, nil
+	}
+
+	return api.ParseSecret(resp.Body)
+}
+
+func vaultRequest[T any](ctx context.Context, fn func() (T, error)) (T, error) {
+	if cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.Timeout)
+		defer cancel()
+	}
+
+	type result struct {
+		value T
+		err   error
+	}
+
+	res := make(chan result, 1)
+	go func() {
+		value, err := fn()
+		res <- result{value, err}
+	}()
+
+	var empty T
+	select {
+	case r := <-res:
+		return r.value, r.err
+	case <-ctx.Done():
+		if ctx.Err() == context.DeadlineExceeded {
+			return empty, withExitCode(exitCodeUnreachable, fmt.Errorf("Vault did not respond within %s", cfg.Timeout))
+		}
+		return empty, ctx.Err()
+	}
+}
+
+// selectVaultAddress checks the health of the addresses in order and
+// points the client to the first one responding unsealed. When a token is
+// given per address the corresponding one is used for authentication.
+func selectVaultAddress(ctx context.Context, client *api.Client, addrs, tokens []string) error {
+	for i, addr := range addrs {
+		if err := client.SetAddress(addr); err != nil {
+			return err
+		}
+
+		health, err := vaultRequest(ctx, func() (*api.HealthResponse, error) {
+			return client.Sys().Health()
+		})
+		switch {
+		case err != nil:
+			log.WithFields(log.Fields{"address": addr, "error": err}).Warn("Vault address did not respond")
+			continue
+		case health.Sealed:
+			log.WithField("address", addr).Warn("Vault address is sealed")
+			continue
+		}
+
+		if len(tokens) == len(addrs) {
+			cfg.VaultToken = tokens[i]
+		}
+		log.WithField("address", addr).Info("Selected Vault address")
+		return nil
+	}
+
+	return withExitCode(exitCodeUnreachable, fmt.Errorf("None of the %d Vault addresses responded", len(addrs)))
+}
+
+// vaultPKI contains the requests the PKI functions need to be able to
+// pass them a fake instead of a client talking to Vault
+type vaultPKI interface {
+	Read(ctx context.Context, path string) (*api.Secret, error)
+	List(ctx context.Context, path string) (*api.Secret, error)
+	Write(ctx context.Context, path string, data map[string]interface{}) (*api.Secret, error)
+	ReadRaw(ctx context.Context, path string) ([]byte, error)
+}
+
+// vaultClient implements vaultPKI using the wrappers above
+type vaultClient struct {
+	client *api.Client
+}
+
+func (v vaultClient) Read(ctx context.Context, path string) (*api.Secret, error) {
+	return vaultRead(ctx, v.client, path)
+}
+
+func (v vaultClient) List(ctx context.Context, path string) (*api.Secret, error) {
+	return vaultList(ctx, v.client, path)
+}
+
+func (v vaultClient) Write(ctx context.Context, path string, data map[string]interface{}) (*api.Secret, error) {
+	return vaultWrite(ctx, v.client, path, data)
+}
+
+func (v vaultClient) ReadRaw(ctx context.Context, path string) ([]byte, error) {
+	return vaultReadRaw(ctx, v.client, path)
+}